@@ -0,0 +1,289 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// errRoutesInLoginMode is returned when a request tries to change
+// advertised routes while the Server is in LoginServerMode.
+var errRoutesInLoginMode = errors.New("advertised routes cannot be changed in login mode")
+
+// errReadOnlyMode is returned when a request would mutate prefs or
+// backend state while the Server is in ReadOnlyServerMode.
+var errReadOnlyMode = errors.New("server is in read-only mode")
+
+// newAPIMux builds the mux that serves the versioned JSON API consumed by
+// the web client's frontend (e.g. a React/Vite SPA driving the UI instead
+// of posting the legacy HTML form).
+func (s *Server) newAPIMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/data", s.serveAPIData)
+	mux.HandleFunc("/api/up", s.serveAPIUp)
+	mux.HandleFunc("/api/logout", s.serveAPILogout)
+	mux.HandleFunc("/api/routes", s.serveAPIRoutes)
+	return mux
+}
+
+// csrfProtect wraps the API mux in gorilla/csrf middleware, verifying the
+// token on every request other than GET, HEAD, OPTIONS and TRACE, and
+// exposing a fresh token via the X-CSRF-Token response header for the
+// frontend to echo back; gorilla/csrf keeps its base token in the
+// _mirage_csrf cookie. Secure is set whenever the request arrived over
+// TLS, since that's not knowable until we see the request.
+func (s *Server) csrfProtect(r *http.Request) http.Handler {
+	opts := []csrf.Option{
+		csrf.CookieName("_mirage_csrf"),
+		csrf.Path(s.pathPrefix + "/"),
+		csrf.Secure(r.TLS != nil),
+		csrf.SameSite(csrf.SameSiteStrictMode),
+	}
+	return csrf.Protect(s.csrfKey[:], opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-CSRF-Token", csrf.Token(r))
+		s.apiMux.ServeHTTP(w, r)
+	}))
+}
+
+// writeAPIError writes err to w as a JSON error response with the given
+// status code. In CGIMode it also logs err, for the same reason
+// Server.httpError does.
+func (s *Server) writeAPIError(w http.ResponseWriter, code int, err error) {
+	if s.cgiMode {
+		log.Printf("web: %v", err)
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"error": err.Error()})
+}
+
+func writeAPIOK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}")
+}
+
+// firstDNSLabel returns the first label of a DNS name, e.g. "foo" for
+// "foo.example.ts.net.".
+func firstDNSLabel(dnsName string) string {
+	return strings.Split(dnsName, ".")[0]
+}
+
+// splitAdvertiseRoutes separates the default routes (which indicate an
+// advertised exit node) from the rest of the advertised subnet routes,
+// returning the latter as a comma-separated string.
+func splitAdvertiseRoutes(routes []netip.Prefix) (advertiseRoutes string, advertiseExitNode bool) {
+	exitNodeRouteV4 := netip.MustParsePrefix("0.0.0.0/0")
+	exitNodeRouteV6 := netip.MustParsePrefix("::/0")
+	for _, route := range routes {
+		if route == exitNodeRouteV4 || route == exitNodeRouteV6 {
+			advertiseExitNode = true
+			continue
+		}
+		if advertiseRoutes != "" {
+			advertiseRoutes += ","
+		}
+		advertiseRoutes += route.String()
+	}
+	return advertiseRoutes, advertiseExitNode
+}
+
+// normalizeControlURL applies the same defaulting/scheme rules as the
+// legacy HTML form handler to a control URL supplied via the API.
+func normalizeControlURL(controlURL string) string {
+	switch {
+	case controlURL == "":
+		return ipn.DefaultControlURL
+	case controlURL != "NOUPDATE" && !strings.Contains(controlURL, "https://") && !strings.Contains(controlURL, "http://"):
+		return "https://" + controlURL
+	default:
+		return controlURL
+	}
+}
+
+type apiDataResponse struct {
+	Profile           tailcfg.UserProfile `json:"profile"`
+	Status            string              `json:"status"`
+	DeviceName        string              `json:"deviceName"`
+	IP                string              `json:"ip"`
+	ControlURL        string              `json:"controlURL"`
+	AdvertiseExitNode bool                `json:"advertiseExitNode"`
+	AdvertiseRoutes   string              `json:"advertiseRoutes"`
+}
+
+type apiDataPost struct {
+	ControlURL string `json:"controlURL"`
+}
+
+// serveAPIData handles GET /api/data (return current status/prefs as JSON)
+// and POST /api/data (update the control URL). Advertised routes and exit
+// node status are owned exclusively by /api/routes; this endpoint never
+// touches them, so a client that only wants to change the control URL
+// can't accidentally clear the node's routes by omitting them here.
+func (s *Server) serveAPIData(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	st, err := s.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	prefs, err := s.lc.GetPrefs(ctx)
+	if err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		resp := apiDataResponse{
+			Profile:    st.User[st.Self.UserID],
+			Status:     st.BackendState,
+			DeviceName: firstDNSLabel(st.Self.DNSName),
+			ControlURL: prefs.ControlURL,
+		}
+		if len(st.TailscaleIPs) != 0 {
+			resp.IP = st.TailscaleIPs[0].String()
+		}
+		resp.AdvertiseRoutes, resp.AdvertiseExitNode = splitAdvertiseRoutes(prefs.AdvertiseRoutes)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodPost:
+		if mode := modeForStatus(s.mode, st); mode == ReadOnlyServerMode {
+			s.writeAPIError(w, http.StatusForbidden, errReadOnlyMode)
+			return
+		}
+		var postData apiDataPost
+		if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+			s.writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		controlURL := normalizeControlURL(postData.ControlURL)
+		mp := &ipn.MaskedPrefs{
+			ControlURLSet: controlURL != "NOUPDATE" && prefs.ControlURL != controlURL,
+		}
+		mp.Prefs.ControlURL = controlURL
+		if _, err := s.lc.EditPrefs(ctx, mp); err != nil {
+			s.writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIOK(w)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type apiUpPost struct {
+	Reauthenticate bool `json:"reauthenticate"`
+}
+
+type apiUpResponse struct {
+	URL string `json:"url,omitempty"`
+}
+
+// serveAPIUp handles POST /api/up, starting (or re-authenticating) the
+// backend and returning an interactive login URL if one is needed.
+func (s *Server) serveAPIUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var postData apiUpPost
+	if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+		s.writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx := r.Context()
+	st, err := s.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if modeForStatus(s.mode, st) == ReadOnlyServerMode {
+		s.writeAPIError(w, http.StatusForbidden, errReadOnlyMode)
+		return
+	}
+	url, err := s.mirageUp(ctx, st, postData.Reauthenticate)
+	if err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiUpResponse{URL: url})
+}
+
+// serveAPILogout handles POST /api/logout, logging the node out.
+func (s *Server) serveAPILogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	st, err := s.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if modeForStatus(s.mode, st) == ReadOnlyServerMode {
+		s.writeAPIError(w, http.StatusForbidden, errReadOnlyMode)
+		return
+	}
+	if err := s.lc.Logout(ctx); err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIOK(w)
+}
+
+type apiRoutesPost struct {
+	AdvertiseRoutes   string `json:"advertiseRoutes"`
+	AdvertiseExitNode bool   `json:"advertiseExitNode"`
+}
+
+// serveAPIRoutes handles POST /api/routes, updating only the advertised
+// subnet routes and exit node status.
+func (s *Server) serveAPIRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	st, err := s.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	switch modeForStatus(s.mode, st) {
+	case LoginServerMode:
+		s.writeAPIError(w, http.StatusForbidden, errRoutesInLoginMode)
+		return
+	case ReadOnlyServerMode:
+		s.writeAPIError(w, http.StatusForbidden, errReadOnlyMode)
+		return
+	}
+	var postData apiRoutesPost
+	if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+		s.writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	routes, err := calcAdvertiseRoutes(postData.AdvertiseRoutes, postData.AdvertiseExitNode)
+	if err != nil {
+		s.writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	mp := &ipn.MaskedPrefs{AdvertiseRoutesSet: true}
+	mp.Prefs.AdvertiseRoutes = routes
+	if _, err := s.lc.EditPrefs(ctx, mp); err != nil {
+		s.writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIOK(w)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// fakeLocalClient is a localClient backed by an in-memory ipn.Prefs, for
+// testing handlers that read and mutate prefs via EditPrefs without a
+// live tailscaled. Methods the tests in this file don't exercise panic
+// if called, so an unexpected dependency on them fails loudly.
+type fakeLocalClient struct {
+	status *ipnstate.Status
+	prefs  ipn.Prefs
+}
+
+func (f *fakeLocalClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error) {
+	return f.status, nil
+}
+
+func (f *fakeLocalClient) GetPrefs(ctx context.Context) (*ipn.Prefs, error) {
+	prefs := f.prefs
+	return &prefs, nil
+}
+
+func (f *fakeLocalClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	if mp.ControlURLSet {
+		f.prefs.ControlURL = mp.ControlURL
+	}
+	if mp.AdvertiseRoutesSet {
+		f.prefs.AdvertiseRoutes = mp.AdvertiseRoutes
+	}
+	prefs := f.prefs
+	return &prefs, nil
+}
+
+func (f *fakeLocalClient) Logout(ctx context.Context) error {
+	panic("not implemented")
+}
+
+func (f *fakeLocalClient) Start(ctx context.Context, opts ipn.Options) error {
+	panic("not implemented")
+}
+
+func (f *fakeLocalClient) StartLoginInteractive(ctx context.Context) error {
+	panic("not implemented")
+}
+
+func (f *fakeLocalClient) WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (*tailscale.IPNBusWatcher, error) {
+	panic("not implemented")
+}
+
+func (f *fakeLocalClient) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	panic("not implemented")
+}
+
+// TestAPIDataPostPreservesRoutes is a regression test: /api/data used to
+// always set AdvertiseRoutesSet on every POST, which wiped out any
+// routes or exit node previously set via /api/routes whenever a caller
+// posted just a controlURL. Routes/exit-node are now owned exclusively
+// by /api/routes, so a controlURL-only POST to /api/data must leave
+// them untouched.
+func TestAPIDataPostPreservesRoutes(t *testing.T) {
+	fc := &fakeLocalClient{
+		status: &ipnstate.Status{BackendState: ipn.Running.String(), Self: &ipnstate.PeerStatus{}},
+	}
+	s := &Server{lc: fc, mode: ManageServerMode}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/routes", strings.NewReader(`{"advertiseRoutes":"10.0.0.0/24","advertiseExitNode":true}`))
+	w := httptest.NewRecorder()
+	s.serveAPIRoutes(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("serveAPIRoutes: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	r2 := httptest.NewRequest(http.MethodPost, "/api/data", strings.NewReader(`{"controlURL":"https://example.com"}`))
+	w2 := httptest.NewRecorder()
+	s.serveAPIData(w2, r2)
+	if w2.Code != http.StatusOK {
+		t.Fatalf("serveAPIData: status = %d, body = %s", w2.Code, w2.Body)
+	}
+
+	if got, want := fc.prefs.ControlURL, "https://example.com"; got != want {
+		t.Errorf("ControlURL = %q, want %q", got, want)
+	}
+	advertiseRoutes, advertiseExitNode := splitAdvertiseRoutes(fc.prefs.AdvertiseRoutes)
+	if advertiseRoutes != "10.0.0.0/24" || !advertiseExitNode {
+		t.Errorf("routes clobbered by /api/data post: advertiseRoutes = %q, advertiseExitNode = %v, want %q, true", advertiseRoutes, advertiseExitNode, "10.0.0.0/24")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+)
+
+// viteDevPort is the port the Vite dev server is expected to listen on.
+// This matches Vite's own default, so no extra configuration is needed
+// on the frontend side.
+const viteDevPort = "5173"
+
+// newDevProxy returns a reverse proxy that forwards requests to a Vite
+// dev server running on viteDevPort, so that .tsx/.css/.js assets are
+// served with HMR while /api/ requests are still handled by the Go
+// server.
+func newDevProxy() (*httputil.ReverseProxy, error) {
+	target, err := url.Parse("http://127.0.0.1:" + viteDevPort)
+	if err != nil {
+		return nil, err
+	}
+	return httputil.NewSingleHostReverseProxy(target), nil
+}
+
+// startViteDevServer spawns the frontend's dev server (`yarn dev`,
+// falling back to `npm run dev`) with its working directory set to dir.
+// The returned cleanup func terminates the subprocess; it is always
+// non-nil when err is nil.
+func startViteDevServer(dir string) (cleanup func(), err error) {
+	name, args := "yarn", []string{"dev"}
+	if _, err := exec.LookPath(name); err != nil {
+		name, args = "npm", []string{"run", "dev"}
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting vite dev server: %w", err)
+	}
+	return func() {
+		if cmd.Process == nil {
+			return
+		}
+		if err := cmd.Process.Kill(); err != nil {
+			log.Printf("web: killing vite dev server: %v", err)
+		}
+		cmd.Wait()
+	}, nil
+}
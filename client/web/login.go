@@ -0,0 +1,48 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"html/template"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// modeForStatus resolves the effective ServerMode for a request. If
+// explicit is non-empty it always wins (this is how the CLI's
+// "-mode=manage" flag overrides auto-detection). Otherwise, a node that
+// isn't Running (NeedsLogin, NeedsMachineAuth, etc.) gets
+// LoginServerMode so an admin can claim it without the full prefs
+// surface being exposed.
+func modeForStatus(explicit ServerMode, st *ipnstate.Status) ServerMode {
+	if explicit != "" {
+		return explicit
+	}
+	if st.BackendState != ipn.Running.String() {
+		return LoginServerMode
+	}
+	return ManageServerMode
+}
+
+// loginPageData is the data passed to loginTmpl.
+type loginPageData struct {
+	DeviceName string
+	Status     string
+	AuthURL    string
+}
+
+// loginPageHTML is a minimal page offering only "Log in" and "Switch
+// account" actions, served in LoginServerMode in place of the full
+// manage UI.
+const loginPageHTML = `<html><body>
+<h1>{{.DeviceName}}</h1>
+<p>Status: {{.Status}}</p>
+{{with .AuthURL}}<p><a href="{{.}}">Log in</a></p>{{end}}
+<form method="POST" action="/api/up"><button type="submit">Log in</button></form>
+<form method="POST" action="/api/logout"><button type="submit">Switch account</button></form>
+</body></html>
+`
+
+var loginTmpl = template.Must(template.New("login.html").Parse(loginPageHTML))
@@ -0,0 +1,36 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestModeForStatus(t *testing.T) {
+	running := &ipnstate.Status{BackendState: ipn.Running.String()}
+	needsLogin := &ipnstate.Status{BackendState: ipn.NeedsLogin.String()}
+
+	tests := []struct {
+		name     string
+		explicit ServerMode
+		st       *ipnstate.Status
+		want     ServerMode
+	}{
+		{"auto-running", "", running, ManageServerMode},
+		{"auto-needs-login", "", needsLogin, LoginServerMode},
+		{"explicit-manage-wins-over-needs-login", ManageServerMode, needsLogin, ManageServerMode},
+		{"explicit-login-wins-over-running", LoginServerMode, running, LoginServerMode},
+		{"explicit-readonly-wins-over-running", ReadOnlyServerMode, running, ReadOnlyServerMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modeForStatus(tt.explicit, tt.st); got != tt.want {
+				t.Errorf("modeForStatus(%q, %v) = %q, want %q", tt.explicit, tt.st.BackendState, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// ServerMode controls which actions are available through a Server,
+// independent of how requests to it are authorized.
+type ServerMode string
+
+const (
+	// ManageServerMode is the default mode: the full prefs surface is
+	// readable and writable by an authorized user.
+	ManageServerMode ServerMode = "manage"
+
+	// LoginServerMode restricts the UI to logging in and switching
+	// accounts, for use on nodes that aren't claimed yet.
+	LoginServerMode ServerMode = "login"
+
+	// ReadOnlyServerMode serves status information but rejects any
+	// request that would change prefs.
+	ReadOnlyServerMode ServerMode = "readonly"
+)
+
+// TailnetListenPort is the fixed port the web client listens on, via
+// ListenTailnet, when it serves the UI to the whole tailnet (as
+// "mirage web -tailnet-listen" does) rather than on a local address.
+// tailscaled itself does not open this listener.
+const TailnetListenPort = 5252
+
+// WebUIAccessCap is the tailnet policy capability that, when granted to a
+// peer for this node, allows that peer to use the web UI even though
+// they are not the node's owner.
+const WebUIAccessCap tailcfg.PeerCapability = "https://mirage.dev/cap/webui-access"
+
+// ListenTailnet opens a listener on TailnetListenPort bound to the
+// node's first Tailscale IP, for use with a Server in TailnetMode (as
+// opposed to the "mirage web" CLI binding to a local address). The
+// node's other Tailscale IPs are not listened on.
+func ListenTailnet(ctx context.Context, lc localClient) (net.Listener, error) {
+	st, err := lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting status: %w", err)
+	}
+	if len(st.TailscaleIPs) == 0 {
+		return nil, fmt.Errorf("node has no Tailscale IPs yet")
+	}
+	return net.Listen("tcp", net.JoinHostPort(st.TailscaleIPs[0].String(), strconv.Itoa(TailnetListenPort)))
+}
+
+// tailnetPeerAuthorizer authorizes a request arriving over the tailnet
+// listener (as opposed to a distro-specific CGI context) by looking up
+// the remote peer via WhoIs. Only the node's owner, or peers granted
+// WebUIAccessCap by tailnet policy, are allowed through.
+type tailnetPeerAuthorizer struct {
+	lc localClient
+}
+
+func (a *tailnetPeerAuthorizer) Authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ctx := r.Context()
+	whois, err := a.lc.WhoIs(ctx, r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	st, err := a.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	user, ok := decideTailnetPeerAuthz(whois, st.Self.UserID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("tailnet peer %s is not authorized for the web UI", peerDisplayName(whois)), http.StatusForbidden)
+		return "", false
+	}
+	return user, true
+}
+
+// decideTailnetPeerAuthz is the pure decision logic behind
+// tailnetPeerAuthorizer.Authorize, split out so it can be tested
+// without a live LocalClient: it reports whether whois (the caller)
+// should be let through, given selfUserID (the node owner's user ID),
+// and the identity to report for the caller if so. A peer is let
+// through if it owns the node or holds WebUIAccessCap. Ownership is
+// judged from whois.UserProfile, so a peer without a UserProfile (e.g. a
+// tagged/service peer) is let through only via the capability, and then
+// reports an empty user.
+func decideTailnetPeerAuthz(whois *apitype.WhoIsResponse, selfUserID tailcfg.UserID) (user string, ok bool) {
+	isOwner := whois.UserProfile != nil && whois.UserProfile.ID == selfUserID
+	if !isOwner && !whoIsHasCap(whois, WebUIAccessCap) {
+		return "", false
+	}
+	if whois.UserProfile == nil {
+		// A tagged/service node with WebUIAccessCap but no user identity.
+		return "", true
+	}
+	return whois.UserProfile.LoginName, true
+}
+
+// peerDisplayName returns a human-readable identifier for whois for use
+// in error messages, falling back to the node's name when it has no
+// associated user (e.g. a tagged node).
+func peerDisplayName(whois *apitype.WhoIsResponse) string {
+	if whois.UserProfile != nil && whois.UserProfile.LoginName != "" {
+		return whois.UserProfile.LoginName
+	}
+	if whois.Node != nil && whois.Node.Name != "" {
+		return whois.Node.Name
+	}
+	return "unknown"
+}
+
+func whoIsHasCap(whois *apitype.WhoIsResponse, capability tailcfg.PeerCapability) bool {
+	if whois == nil || whois.CapMap == nil {
+		return false
+	}
+	_, ok := whois.CapMap[capability]
+	return ok
+}
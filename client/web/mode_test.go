@@ -0,0 +1,130 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestDecideTailnetPeerAuthz(t *testing.T) {
+	const selfUserID = tailcfg.UserID(1)
+	const otherUserID = tailcfg.UserID(2)
+
+	tests := []struct {
+		name     string
+		whois    *apitype.WhoIsResponse
+		wantUser string
+		wantOK   bool
+	}{
+		{
+			name: "owner",
+			whois: &apitype.WhoIsResponse{
+				UserProfile: &tailcfg.UserProfile{ID: selfUserID, LoginName: "alice@example.com"},
+			},
+			wantUser: "alice@example.com",
+			wantOK:   true,
+		},
+		{
+			name: "non-owner-with-cap",
+			whois: &apitype.WhoIsResponse{
+				UserProfile: &tailcfg.UserProfile{ID: otherUserID, LoginName: "bob@example.com"},
+				CapMap:      tailcfg.PeerCapMap{WebUIAccessCap: nil},
+			},
+			wantUser: "bob@example.com",
+			wantOK:   true,
+		},
+		{
+			name: "non-owner-without-cap",
+			whois: &apitype.WhoIsResponse{
+				UserProfile: &tailcfg.UserProfile{ID: otherUserID, LoginName: "bob@example.com"},
+			},
+			wantUser: "",
+			wantOK:   false,
+		},
+		{
+			// Regression test: a tagged/service peer has no
+			// UserProfile at all. This must not panic, and must be
+			// rejected unless it holds WebUIAccessCap.
+			name: "nil-user-profile-without-cap",
+			whois: &apitype.WhoIsResponse{
+				UserProfile: nil,
+				Node:        &tailcfg.Node{Name: "service-node.example.ts.net."},
+			},
+			wantUser: "",
+			wantOK:   false,
+		},
+		{
+			name: "nil-user-profile-with-cap",
+			whois: &apitype.WhoIsResponse{
+				UserProfile: nil,
+				CapMap:      tailcfg.PeerCapMap{WebUIAccessCap: nil},
+			},
+			wantUser: "",
+			wantOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, ok := decideTailnetPeerAuthz(tt.whois, selfUserID)
+			if user != tt.wantUser || ok != tt.wantOK {
+				t.Errorf("decideTailnetPeerAuthz() = (%q, %v), want (%q, %v)", user, ok, tt.wantUser, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPeerDisplayName(t *testing.T) {
+	tests := []struct {
+		name  string
+		whois *apitype.WhoIsResponse
+		want  string
+	}{
+		{
+			name:  "has-login-name",
+			whois: &apitype.WhoIsResponse{UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"}},
+			want:  "alice@example.com",
+		},
+		{
+			name:  "falls-back-to-node-name",
+			whois: &apitype.WhoIsResponse{Node: &tailcfg.Node{Name: "service-node.example.ts.net."}},
+			want:  "service-node.example.ts.net.",
+		},
+		{
+			name:  "falls-back-to-unknown",
+			whois: &apitype.WhoIsResponse{},
+			want:  "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := peerDisplayName(tt.whois); got != tt.want {
+				t.Errorf("peerDisplayName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhoIsHasCap(t *testing.T) {
+	tests := []struct {
+		name  string
+		whois *apitype.WhoIsResponse
+		want  bool
+	}{
+		{"nil-whois", nil, false},
+		{"nil-capmap", &apitype.WhoIsResponse{}, false},
+		{"missing-cap", &apitype.WhoIsResponse{CapMap: tailcfg.PeerCapMap{"other-cap": nil}}, false},
+		{"has-cap", &apitype.WhoIsResponse{CapMap: tailcfg.PeerCapMap{WebUIAccessCap: nil}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := whoIsHasCap(tt.whois, WebUIAccessCap); got != tt.want {
+				t.Errorf("whoIsHasCap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
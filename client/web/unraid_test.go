@@ -0,0 +1,44 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnraidAuthorizerAuthorize(t *testing.T) {
+	t.Setenv("UNRAID_CSRF_TOKEN", "test-token")
+
+	tests := []struct {
+		name       string
+		method     string
+		headerTok  string
+		wantOK     bool
+		wantStatus int
+	}{
+		{"get-always-allowed", http.MethodGet, "", true, 0},
+		{"head-always-allowed", http.MethodHead, "", true, 0},
+		{"post-with-matching-token", http.MethodPost, "test-token", true, 0},
+		{"post-with-wrong-token", http.MethodPost, "wrong", false, http.StatusForbidden},
+		{"post-with-no-token", http.MethodPost, "", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.headerTok != "" {
+				r.Header.Set("X-Csrf-Token", tt.headerTok)
+			}
+			w := httptest.NewRecorder()
+			_, ok := (unraidAuthorizer{}).Authorize(w, r)
+			if ok != tt.wantOK {
+				t.Errorf("Authorize() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantOK && w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package web provides the Mirage client's web UI, a self-contained
+// http.Handler that can either be run standalone (as "mirage web" does)
+// or embedded inside another program (for example a tsnet server) that
+// wants to offer node management without shelling out to the CLI.
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/version/distro"
+)
+
+// localClient is the subset of *tailscale.LocalClient's API that Server
+// and its Authorizers depend on. Defining it as an interface lets
+// handler tests exercise Server against a fake backend instead of a
+// live tailscaled; *tailscale.LocalClient satisfies it.
+type localClient interface {
+	StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error)
+	GetPrefs(ctx context.Context) (*ipn.Prefs, error)
+	EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error)
+	Logout(ctx context.Context) error
+	Start(ctx context.Context, opts ipn.Options) error
+	StartLoginInteractive(ctx context.Context) error
+	WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (*tailscale.IPNBusWatcher, error)
+	WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+}
+
+//go:embed web.html
+var webHTML string
+
+//go:embed web.css
+var webCSS string
+
+//go:embed auth-redirect.html
+var authenticationRedirectHTML string
+
+var tmpl *template.Template
+
+func init() {
+	tmpl = template.Must(template.New("web.html").Parse(webHTML))
+	template.Must(tmpl.New("web.css").Parse(webCSS))
+}
+
+// ServerOpts contains the parameters needed to construct a Server.
+type ServerOpts struct {
+	// LocalClient is the client used to talk to the local tailscaled.
+	// If nil, a zero-value tailscale.LocalClient is used, which talks to
+	// the default local tailscaled on this machine.
+	LocalClient *tailscale.LocalClient
+
+	// CGIMode indicates that the server is being run as a CGI script
+	// (e.g. by net/http/cgi.Serve, as "mirage web -cgi" does). A CGI
+	// host's stderr is not always surfaced to whoever is operating the
+	// NAS web UI the way a standalone server's logs are, so in this
+	// mode internal errors are also logged via the log package in
+	// addition to being returned to the client.
+	CGIMode bool
+
+	// PathPrefix is the prefix in front of all HTTP paths. It is
+	// used to generate links and parse incoming requests, but is not
+	// itself part of the mux pattern. It should be empty, or start
+	// with a '/', and not end with a '/'.
+	PathPrefix string
+
+	// DevMode, if true, serves the frontend assets by proxying to a
+	// local Vite dev server instead of the embedded bundle. DevAssetsDir
+	// must also be set.
+	DevMode bool
+
+	// DevAssetsDir is the frontend source directory to run the Vite
+	// dev server from. It is only consulted when DevMode is true.
+	DevAssetsDir string
+
+	// Mode selects which actions the UI exposes. The zero value
+	// auto-selects LoginServerMode whenever the node's BackendState
+	// isn't Running, and ManageServerMode otherwise; set it explicitly
+	// to override that detection (e.g. the CLI's "-mode=manage" flag).
+	Mode ServerMode
+
+	// TailnetMode indicates the Server is reachable directly from the
+	// tailnet (e.g. via ListenTailnet), rather than only from
+	// localhost or a distro-specific CGI context. When set, requests
+	// are authorized via LocalClient.WhoIs instead of the distro auth
+	// mechanisms.
+	TailnetMode bool
+}
+
+// Server is the Mirage web UI.
+//
+// It can be mounted as an http.Handler at any path prefix, which makes it
+// suitable for embedding inside other programs (such as a tsnet server),
+// not just running standalone the way "mirage web" does.
+type Server struct {
+	lc          localClient
+	cgiMode     bool
+	pathPrefix  string
+	devMode     bool
+	devProxy    *httputil.ReverseProxy
+	mode        ServerMode
+	tailnetMode bool
+	authz       Authorizer
+
+	csrfKey [32]byte
+	apiMux  *http.ServeMux
+}
+
+// NewServer constructs a new Mirage web client Server. The returned
+// cleanup func must be called once the Server is no longer needed; it is
+// always non-nil, and is only non-trivial in dev mode, where it stops
+// the spawned Vite dev server.
+func NewServer(opts ServerOpts) (s *Server, cleanup func(), err error) {
+	lc := opts.LocalClient
+	if lc == nil {
+		lc = &tailscale.LocalClient{}
+	}
+	s = &Server{
+		lc:          lc,
+		cgiMode:     opts.CGIMode,
+		pathPrefix:  opts.PathPrefix,
+		devMode:     opts.DevMode,
+		mode:        opts.Mode,
+		tailnetMode: opts.TailnetMode,
+	}
+	s.authz = pickAuthorizer(lc, s.tailnetMode)
+	if _, err := rand.Read(s.csrfKey[:]); err != nil {
+		return nil, nil, fmt.Errorf("web: generating CSRF key: %w", err)
+	}
+	s.apiMux = s.newAPIMux()
+
+	cleanup = func() {}
+	if s.devMode {
+		s.devProxy, err = newDevProxy()
+		if err != nil {
+			return nil, nil, fmt.Errorf("web: starting dev proxy: %w", err)
+		}
+		cleanup, err = startViteDevServer(opts.DevAssetsDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("web: starting vite dev server: %w", err)
+		}
+	}
+	return s, cleanup, nil
+}
+
+type tmplData struct {
+	Profile           tailcfg.UserProfile
+	SynologyUser      string
+	Status            string
+	DeviceName        string
+	IP                string
+	AdvertiseExitNode bool
+	AdvertiseRoutes   string
+	LicensesURL       string
+	TUNMode           bool
+	IsSynology        bool
+	DSMVersion        int // 6 or 7, if IsSynology=true
+	IsUnraid          bool
+	UnraidToken       string
+	IPNVersion        string
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = stripPathPrefix(r, s.pathPrefix)
+
+	ctx := r.Context()
+	if s.authRedirect(w, r) {
+		return
+	}
+
+	user, err := s.authorize(w, r)
+	if err != nil {
+		return
+	}
+
+	if r.URL.Path == "/redirect" || r.URL.Path == "/redirect/" {
+		io.WriteString(w, authenticationRedirectHTML)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		s.csrfProtect(r).ServeHTTP(w, r)
+		return
+	}
+
+	if s.devMode {
+		s.devProxy.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// All prefs-mutating requests go through the CSRF-protected
+		// /api/ surface; this path only ever renders a page, so it
+		// never needs to consult the backend for non-GET methods.
+		http.Error(w, "method not allowed; use the /api/ endpoints", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st, err := s.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		s.httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	prefs, err := s.lc.GetPrefs(ctx)
+	if err != nil {
+		s.httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	mode := modeForStatus(s.mode, st)
+
+	if mode == LoginServerMode {
+		buf := new(bytes.Buffer)
+		if err := loginTmpl.Execute(buf, loginPageData{
+			DeviceName: firstDNSLabel(st.Self.DNSName),
+			Status:     st.BackendState,
+			AuthURL:    st.AuthURL,
+		}); err != nil {
+			s.httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Write(buf.Bytes())
+		return
+	}
+
+	profile := st.User[st.Self.UserID]
+	deviceName := strings.Split(st.Self.DNSName, ".")[0]
+	versionShort := strings.Split(st.Version, "-")[0]
+	data := tmplData{
+		SynologyUser: user,
+		Profile:      profile,
+		Status:       st.BackendState,
+		DeviceName:   deviceName,
+		LicensesURL:  licensesURL(),
+		TUNMode:      st.TUN,
+		IsSynology:   distro.Get() == distro.Synology || envknob.Bool("TS_FAKE_SYNOLOGY"),
+		DSMVersion:   distro.DSMVersion(),
+		IsUnraid:     distro.Get() == distro.Unraid,
+		UnraidToken:  unraidCSRFToken(),
+		IPNVersion:   versionShort,
+	}
+	exitNodeRouteV4 := netip.MustParsePrefix("0.0.0.0/0")
+	exitNodeRouteV6 := netip.MustParsePrefix("::/0")
+	for _, route := range prefs.AdvertiseRoutes {
+		if route == exitNodeRouteV4 || route == exitNodeRouteV6 {
+			data.AdvertiseExitNode = true
+		} else {
+			if data.AdvertiseRoutes != "" {
+				data.AdvertiseRoutes += ","
+			}
+			data.AdvertiseRoutes += route.String()
+		}
+	}
+	if len(st.TailscaleIPs) != 0 {
+		data.IP = st.TailscaleIPs[0].String()
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		s.httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// httpError writes err to w as the response body with the given status
+// code. In CGIMode it also logs err, since a CGI script's stderr is not
+// always surfaced to the operator the way a standalone server's logs
+// are.
+func (s *Server) httpError(w http.ResponseWriter, err error, code int) {
+	if s.cgiMode {
+		log.Printf("web: %v", err)
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// mirageUp starts the backend (if not already running) and/or forces
+// re-authentication, returning an interactive login URL the caller
+// should direct the user to, if one was produced.
+func (s *Server) mirageUp(ctx context.Context, st *ipnstate.Status, forceReauth bool) (authURL string, retErr error) {
+	origAuthURL := st.AuthURL
+	isRunning := st.BackendState == ipn.Running.String()
+
+	if !forceReauth {
+		if origAuthURL != "" {
+			return origAuthURL, nil
+		}
+		if isRunning {
+			return "", nil
+		}
+	}
+
+	// printAuthURL reports whether we should print out the
+	// provided auth URL from an IPN notify.
+	printAuthURL := func(url string) bool {
+		return url != origAuthURL
+	}
+
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
+	watcher, err := s.lc.WatchIPNBus(watchCtx, 0)
+	if err != nil {
+		return "", err
+	}
+	defer watcher.Close()
+
+	go func() {
+		if !isRunning {
+			s.lc.Start(ctx, ipn.Options{})
+		}
+		if forceReauth {
+			s.lc.StartLoginInteractive(ctx)
+		}
+	}()
+
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			return "", err
+		}
+		if n.ErrMessage != nil {
+			msg := *n.ErrMessage
+			return "", fmt.Errorf("backend error: %v", msg)
+		}
+		if url := n.BrowseToURL; url != nil && printAuthURL(*url) {
+			return *url, nil
+		}
+	}
+}
+
+// calcAdvertiseRoutes formats a comma-separated list of CIDR prefixes
+// (plus, if advertiseExitNode is set, the default routes) for use in a
+// MaskedPrefs.AdvertiseRoutes edit.
+func calcAdvertiseRoutes(advertiseRoutes string, advertiseExitNode bool) ([]netip.Prefix, error) {
+	var routeMap map[netip.Prefix]bool
+	if advertiseRoutes != "" {
+		var err error
+		routeMap = make(map[netip.Prefix]bool)
+		for _, s := range strings.Split(advertiseRoutes, ",") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad CIDR prefix %q: %w", s, err)
+			}
+			routeMap[prefix.Masked()] = true
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if advertiseExitNode {
+		if routeMap == nil {
+			routeMap = make(map[netip.Prefix]bool)
+		}
+		routeMap[netip.MustParsePrefix("0.0.0.0/0")] = true
+		routeMap[netip.MustParsePrefix("::/0")] = true
+	}
+	routes := make([]netip.Prefix, 0, len(routeMap))
+	for r := range routeMap {
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// licensesURL returns the URL the web UI should link to for open source
+// license information.
+func licensesURL() string {
+	return "https://mirage.dev/licenses/"
+}
+
+// stripPathPrefix removes prefix from the front of r.URL.Path (and
+// r.URL.RawPath, if set), so handlers written against the package root
+// work regardless of where a host program mounts the Server.
+func stripPathPrefix(r *http.Request, prefix string) *http.Request {
+	if prefix == "" {
+		return r
+	}
+	if p := strings.TrimPrefix(r.URL.Path, prefix); len(p) < len(r.URL.Path) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = p
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		if r2.URL.RawPath != "" {
+			r2.URL.RawPath = strings.TrimPrefix(r2.URL.RawPath, prefix)
+		}
+		return r2
+	}
+	return r
+}
+
+// Authorizer decides whether an incoming request to the web UI is
+// permitted, returning the local or tailnet identity of the caller.
+// Implementations write their own error response to w when ok is
+// false. "User" here is whatever concept of identity the underlying
+// platform has (a local OS user, a NAS account, a tailnet peer); it is
+// not necessarily a tailnet account.
+type Authorizer interface {
+	Authorize(w http.ResponseWriter, r *http.Request) (user string, ok bool)
+}
+
+// redirector is implemented by Authorizers that need to intercept a
+// request before authorization is even attempted, e.g. to redirect the
+// browser somewhere that can obtain a token. It reports whether it
+// fully handled the request, in which case the caller must not write
+// anything further to w.
+type redirector interface {
+	redirect(w http.ResponseWriter, r *http.Request) bool
+}
+
+// errNotAuthorized is returned by Server.authorize when the configured
+// Authorizer rejects a request; the Authorizer has already written its
+// own response to the client by the time this is returned.
+var errNotAuthorized = fmt.Errorf("not authorized")
+
+// pickAuthorizer selects the Authorizer appropriate for how s is being
+// run: tailnet-wide peer auth when TailnetMode is set, otherwise
+// whichever distro-specific mechanism applies, falling back to no
+// authorization at all (the historical behavior for "mirage web"
+// listening on localhost).
+func pickAuthorizer(lc localClient, tailnetMode bool) Authorizer {
+	if tailnetMode {
+		return &tailnetPeerAuthorizer{lc: lc}
+	}
+	switch distro.Get() {
+	case distro.Synology:
+		return &synoAuthorizer{}
+	case distro.QNAP:
+		return &qnapAuthorizer{}
+	case distro.Unraid:
+		return &unraidAuthorizer{}
+	default:
+		return noAuthorizer{}
+	}
+}
+
+// noAuthorizer grants access unconditionally. It's used when the web UI
+// is only reachable by whoever can already reach the listening address
+// (typically localhost).
+type noAuthorizer struct{}
+
+func (noAuthorizer) Authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return "", true
+}
+
+// authorize runs the Server's configured Authorizer, translating its
+// result into the (user, error) shape the rest of the package expects.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) (string, error) {
+	user, ok := s.authz.Authorize(w, r)
+	if !ok {
+		return "", errNotAuthorized
+	}
+	return user, nil
+}
+
+// authRedirect gives the configured Authorizer a chance to intercept the
+// request before authorization proper, reporting whether it did.
+func (s *Server) authRedirect(w http.ResponseWriter, r *http.Request) bool {
+	rd, ok := s.authz.(redirector)
+	return ok && rd.redirect(w, r)
+}
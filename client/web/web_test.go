@@ -0,0 +1,28 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServeHTTPRejectsNonAPIPost is a regression test for the CSRF hole
+// where a POST to any path other than /api/* reached the legacy
+// form-post handler without CSRF verification. Every prefs mutation now
+// lives behind /api/, which is wrapped in csrfProtect, so a POST
+// elsewhere must be rejected outright before the backend is ever
+// consulted.
+func TestServeHTTPRejectsNonAPIPost(t *testing.T) {
+	s := &Server{authz: noAuthorizer{}}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -4,13 +4,29 @@
 package ipn
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/netip"
+	"strings"
 
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/preftype"
 )
 
+// MagicDNSResolver is the subset of LocalClient's API that ToPrefs needs
+// to resolve an ExitNode MagicDNS name and a Profile's tailnet. It exists
+// so that ToPrefs, which runs daemon-side, depends only on the narrow
+// capability it actually uses rather than the whole client-facing
+// tailscale.LocalClient type; *tailscale.LocalClient satisfies it.
+type MagicDNSResolver interface {
+	Status(ctx context.Context) (*ipnstate.Status, error)
+	WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+}
+
 // ConfigVAlpha is the config file format for the "alpha0" version.
 type ConfigVAlpha struct {
 	Locked opt.Bool `json:",omitempty"` // whether the config is locked from being changed by 'tailscale set'; it defaults to true
@@ -39,15 +55,27 @@ type ConfigVAlpha struct {
 	AutoUpdate      *AutoUpdatePrefs `json:",omitempty"`
 	ServeConfigTemp *ServeConfig     `json:",omitempty"` // TODO(bradfitz,maisem): make separate stable type for this
 
-	// TODO(bradfitz,maisem): future something like:
-	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
+	// Profile holds per-tailnet configs, keyed by TailnetSID (a login
+	// name like "alice@gmail.com" or a managed domain like "corp.com").
+	// When set, ToPrefs resolves the entry for the tailnet the node is
+	// (or would be) logged into and applies it on top of the fields
+	// above.
+	Profile map[string]*ConfigVAlpha `json:",omitempty"`
 }
 
-func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
+// ToPrefs converts c into a MaskedPrefs suitable for passing to
+// LocalClient.EditPrefs. lc is used to resolve an ExitNode specified by
+// MagicDNS name to its StableNodeID; it may be nil, in which case (or on
+// any resolution failure) ExitNode is passed through as a StableNodeID
+// directly, matching the old behavior.
+func (c *ConfigVAlpha) ToPrefs(ctx context.Context, lc MagicDNSResolver) (MaskedPrefs, error) {
 	var mp MaskedPrefs
 	if c == nil {
 		return mp, nil
 	}
+	if err := c.ValidateProfileKeys(); err != nil {
+		return mp, err
+	}
 	if c.ServerURL != nil {
 		mp.ControlURL = *c.ServerURL
 		mp.ControlURLSet = true
@@ -77,6 +105,9 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		if err == nil {
 			mp.ExitNodeIP = ip
 			mp.ExitNodeIPSet = true
+		} else if id, ok := resolveExitNodeMagicDNSName(ctx, lc, *c.ExitNode); ok {
+			mp.ExitNodeID = id
+			mp.ExitNodeIDSet = true
 		} else {
 			mp.ExitNodeID = tailcfg.StableNodeID(*c.ExitNode)
 			mp.ExitNodeIDSet = true
@@ -92,7 +123,7 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	if c.DisableSNAT != "" {
 		mp.NoSNAT = c.DisableSNAT.EqualBool(true)
-		mp.NoSNAT = true
+		mp.NoSNATSet = true
 	}
 	if c.NetfilterMode != nil {
 		m, err := preftype.ParseNetfilterMode(*c.NetfilterMode)
@@ -118,5 +149,210 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.AutoUpdate = *c.AutoUpdate
 		mp.AutoUpdateSet = true
 	}
+
+	if sub, ok := c.resolveProfile(ctx, lc); ok {
+		subMP, err := sub.ToPrefs(ctx, lc)
+		if err != nil {
+			return mp, err
+		}
+		mp = mergeMaskedPrefs(mp, subMP)
+	}
+
 	return mp, nil
 }
+
+// resolveProfile finds the Profile entry, if any, matching the tailnet
+// the node is (or would be) logged into: first by the current user's
+// login name (e.g. "alice@gmail.com"), then by the tailnet's managed
+// domain (e.g. "corp.com"). It reports ok=false if c.Profile is empty,
+// lc is nil, or no entry matches.
+func (c *ConfigVAlpha) resolveProfile(ctx context.Context, lc MagicDNSResolver) (*ConfigVAlpha, bool) {
+	if len(c.Profile) == 0 || lc == nil {
+		return nil, false
+	}
+	st, err := lc.Status(ctx)
+	if err != nil {
+		return nil, false
+	}
+	if login := st.User[st.Self.UserID].LoginName; login != "" {
+		if sub, ok := c.Profile[login]; ok {
+			return sub, true
+		}
+	}
+	if st.CurrentTailnet != nil && st.CurrentTailnet.Name != "" {
+		if sub, ok := c.Profile[st.CurrentTailnet.Name]; ok {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// mergeMaskedPrefs returns the result of applying every field set in
+// override on top of base, leaving base's value for any field override
+// doesn't set. It implements the "Profile entry applies on top of the
+// fields above" behavior documented on ConfigVAlpha.Profile.
+func mergeMaskedPrefs(base, override MaskedPrefs) MaskedPrefs {
+	out := base
+	if override.ControlURLSet {
+		out.ControlURL = override.ControlURL
+		out.ControlURLSet = true
+	}
+	if override.WantRunningSet {
+		out.WantRunning = override.WantRunning
+		out.WantRunningSet = true
+	}
+	if override.OperatorUserSet {
+		out.OperatorUser = override.OperatorUser
+		out.OperatorUserSet = true
+	}
+	if override.HostnameSet {
+		out.Hostname = override.Hostname
+		out.HostnameSet = true
+	}
+	if override.CorpDNSSet {
+		out.CorpDNS = override.CorpDNS
+		out.CorpDNSSet = true
+	}
+	if override.RouteAllSet {
+		out.RouteAll = override.RouteAll
+		out.RouteAllSet = true
+	}
+	if override.ExitNodeIPSet {
+		out.ExitNodeIP = override.ExitNodeIP
+		out.ExitNodeIPSet = true
+		// An IP-based override supersedes any ID-based value from base.
+		out.ExitNodeID = ""
+		out.ExitNodeIDSet = false
+	}
+	if override.ExitNodeIDSet {
+		out.ExitNodeID = override.ExitNodeID
+		out.ExitNodeIDSet = true
+		out.ExitNodeIP = netip.Addr{}
+		out.ExitNodeIPSet = false
+	}
+	if override.ExitNodeAllowLANAccessSet {
+		out.ExitNodeAllowLANAccess = override.ExitNodeAllowLANAccess
+		out.ExitNodeAllowLANAccessSet = true
+	}
+	if override.AdvertiseRoutesSet {
+		out.AdvertiseRoutes = override.AdvertiseRoutes
+		out.AdvertiseRoutesSet = true
+	}
+	if override.NoSNATSet {
+		out.NoSNAT = override.NoSNAT
+		out.NoSNATSet = true
+	}
+	if override.NetfilterModeSet {
+		out.NetfilterMode = override.NetfilterMode
+		out.NetfilterModeSet = true
+	}
+	if override.PostureCheckingSet {
+		out.PostureChecking = override.PostureChecking
+		out.PostureCheckingSet = true
+	}
+	if override.RunSSHSet {
+		out.RunSSH = override.RunSSH
+		out.RunSSHSet = true
+	}
+	if override.ShieldsUpSet {
+		out.ShieldsUp = override.ShieldsUp
+		out.ShieldsUpSet = true
+	}
+	if override.AutoUpdateSet {
+		out.AutoUpdate = override.AutoUpdate
+		out.AutoUpdateSet = true
+	}
+	return out
+}
+
+// resolveExitNodeMagicDNSName resolves name (a MagicDNS base name, e.g.
+// "my-exit-node") to the StableNodeID of the matching tailnet peer. It
+// resolves name to an IP and confirms that IP belongs to a tailnet peer
+// via lc.WhoIs, so the result can only ever be a node actually on the
+// tailnet. It reports ok=false if lc is nil or the lookup fails for any
+// reason, so callers can fall back to treating name as a StableNodeID
+// directly.
+func resolveExitNodeMagicDNSName(ctx context.Context, lc MagicDNSResolver, name string) (id tailcfg.StableNodeID, ok bool) {
+	if lc == nil {
+		return "", false
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil || len(ips) == 0 {
+		return "", false
+	}
+	whois, err := lc.WhoIs(ctx, ips[0])
+	if err != nil || whois.Node == nil {
+		return "", false
+	}
+	return whois.Node.StableID, true
+}
+
+// PrefsToConfigVAlpha converts p into a ConfigVAlpha, the inverse of
+// ToPrefs, so that "tailscale set" can emit a config file capturing the
+// node's current prefs. The returned config's Profile is always nil:
+// callers that maintain a multi-profile config file are responsible for
+// placing the result under the appropriate TailnetSID key themselves.
+func PrefsToConfigVAlpha(p *Prefs) *ConfigVAlpha {
+	var c ConfigVAlpha
+	if p == nil {
+		return &c
+	}
+	c.ServerURL = ptrOrNil(p.ControlURL)
+	c.Enabled = opt.NewBool(p.WantRunning)
+	c.OperatorUser = ptrOrNil(p.OperatorUser)
+	c.Hostname = ptrOrNil(p.Hostname)
+	c.AcceptDNS = opt.NewBool(p.CorpDNS)
+	c.AcceptRoutes = opt.NewBool(p.RouteAll)
+	switch {
+	case p.ExitNodeIP.IsValid():
+		c.ExitNode = ptrOrNil(p.ExitNodeIP.String())
+	case p.ExitNodeID != "":
+		c.ExitNode = ptrOrNil(string(p.ExitNodeID))
+	}
+	c.AllowLANWhileUsingExitNode = opt.NewBool(p.ExitNodeAllowLANAccess)
+	c.AdvertiseRoutes = p.AdvertiseRoutes
+	c.DisableSNAT = opt.NewBool(p.NoSNAT)
+	c.NetfilterMode = ptrOrNil(p.NetfilterMode.String())
+	c.PostureChecking = opt.NewBool(p.PostureChecking)
+	c.RunSSHServer = opt.NewBool(p.RunSSH)
+	c.ShieldsUp = opt.NewBool(p.ShieldsUp)
+	if p.AutoUpdate != (AutoUpdatePrefs{}) {
+		au := p.AutoUpdate
+		c.AutoUpdate = &au
+	}
+	return &c
+}
+
+// ptrOrNil returns nil for the zero value of s, and &s otherwise, so
+// that PrefsToConfigVAlpha round-trips cleanly with the omitempty
+// pointer fields in ConfigVAlpha.
+func ptrOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// ValidateProfileKeys reports an error if any key of c.Profile is not a
+// well-formed TailnetSID: either a login name ("alice@gmail.com") or a
+// managed domain ("corp.com").
+func (c *ConfigVAlpha) ValidateProfileKeys() error {
+	for sid := range c.Profile {
+		if !isValidTailnetSID(sid) {
+			return fmt.Errorf("invalid Profile key %q: not a login name or domain", sid)
+		}
+	}
+	return nil
+}
+
+// isValidTailnetSID reports whether sid looks like a login name
+// ("alice@gmail.com") or a bare domain ("corp.com").
+func isValidTailnetSID(sid string) bool {
+	if sid == "" || strings.ContainsAny(sid, " \t\n") {
+		return false
+	}
+	if user, domain, ok := strings.Cut(sid, "@"); ok {
+		return user != "" && strings.Contains(domain, ".")
+	}
+	return strings.Contains(sid, ".")
+}
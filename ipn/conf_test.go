@@ -0,0 +1,209 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/types/opt"
+)
+
+// TestConfigVAlphaRoundTrip checks that ToPrefs and PrefsToConfigVAlpha
+// are inverses of each other for the fields they both handle: applying
+// a ConfigVAlpha's MaskedPrefs to a zero Prefs and converting the result
+// back should reproduce the same (non-zero-valued) fields.
+func TestConfigVAlphaRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *ConfigVAlpha
+	}{
+		{
+			name: "empty",
+			c:    &ConfigVAlpha{},
+		},
+		{
+			name: "basic",
+			c: &ConfigVAlpha{
+				ServerURL:    ptr("https://controlplane.tailscale.com"),
+				Enabled:      opt.NewBool(true),
+				OperatorUser: ptr("alice"),
+				Hostname:     ptr("my-host"),
+				AcceptDNS:    opt.NewBool(true),
+				AcceptRoutes: opt.NewBool(false),
+			},
+		},
+		{
+			name: "exit-node-ip",
+			c: &ConfigVAlpha{
+				ExitNode:                   ptr("100.64.0.1"),
+				AllowLANWhileUsingExitNode: opt.NewBool(true),
+			},
+		},
+		{
+			name: "routes-and-snat",
+			c: &ConfigVAlpha{
+				AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+				DisableSNAT:     opt.NewBool(true),
+			},
+		},
+		{
+			name: "posture-ssh-shields",
+			c: &ConfigVAlpha{
+				PostureChecking: opt.NewBool(true),
+				RunSSHServer:    opt.NewBool(true),
+				ShieldsUp:       opt.NewBool(false),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp, err := tt.c.ToPrefs(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("ToPrefs: %v", err)
+			}
+
+			// The NoSNAT bug regression: DisableSNAT=true must set
+			// NoSNATSet, not just NoSNAT, or EditPrefs would silently
+			// drop the field.
+			if tt.c.DisableSNAT != "" && !mp.NoSNATSet {
+				t.Errorf("NoSNATSet not set for DisableSNAT=%v", tt.c.DisableSNAT)
+			}
+
+			p := mp.Prefs
+			got := PrefsToConfigVAlpha(&p)
+
+			if tt.c.ServerURL != nil && (got.ServerURL == nil || *got.ServerURL != *tt.c.ServerURL) {
+				t.Errorf("ServerURL round-trip: got %v, want %v", got.ServerURL, tt.c.ServerURL)
+			}
+			if tt.c.OperatorUser != nil && (got.OperatorUser == nil || *got.OperatorUser != *tt.c.OperatorUser) {
+				t.Errorf("OperatorUser round-trip: got %v, want %v", got.OperatorUser, tt.c.OperatorUser)
+			}
+			if tt.c.Hostname != nil && (got.Hostname == nil || *got.Hostname != *tt.c.Hostname) {
+				t.Errorf("Hostname round-trip: got %v, want %v", got.Hostname, tt.c.Hostname)
+			}
+			if tt.c.ExitNode != nil && (got.ExitNode == nil || *got.ExitNode != *tt.c.ExitNode) {
+				t.Errorf("ExitNode round-trip: got %v, want %v", got.ExitNode, tt.c.ExitNode)
+			}
+			if len(tt.c.AdvertiseRoutes) > 0 && len(got.AdvertiseRoutes) != len(tt.c.AdvertiseRoutes) {
+				t.Errorf("AdvertiseRoutes round-trip: got %v, want %v", got.AdvertiseRoutes, tt.c.AdvertiseRoutes)
+			}
+			if tt.c.DisableSNAT != "" && got.DisableSNAT != tt.c.DisableSNAT {
+				t.Errorf("DisableSNAT round-trip: got %v, want %v", got.DisableSNAT, tt.c.DisableSNAT)
+			}
+			for _, f := range []struct {
+				name      string
+				got, want opt.Bool
+			}{
+				{"Enabled", got.Enabled, tt.c.Enabled},
+				{"AcceptDNS", got.AcceptDNS, tt.c.AcceptDNS},
+				{"AcceptRoutes", got.AcceptRoutes, tt.c.AcceptRoutes},
+				{"AllowLANWhileUsingExitNode", got.AllowLANWhileUsingExitNode, tt.c.AllowLANWhileUsingExitNode},
+				{"PostureChecking", got.PostureChecking, tt.c.PostureChecking},
+				{"RunSSHServer", got.RunSSHServer, tt.c.RunSSHServer},
+				{"ShieldsUp", got.ShieldsUp, tt.c.ShieldsUp},
+			} {
+				if f.want != "" && f.got != f.want {
+					t.Errorf("%s round-trip: got %v, want %v", f.name, f.got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateProfileKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile map[string]*ConfigVAlpha
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"login-name", map[string]*ConfigVAlpha{"alice@gmail.com": {}}, false},
+		{"domain", map[string]*ConfigVAlpha{"corp.com": {}}, false},
+		{"bad-no-dot", map[string]*ConfigVAlpha{"notadomain": {}}, true},
+		{"bad-empty-user", map[string]*ConfigVAlpha{"@corp.com": {}}, true},
+		{"bad-whitespace", map[string]*ConfigVAlpha{"alice @corp.com": {}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ConfigVAlpha{Profile: tt.profile}
+			err := c.ValidateProfileKeys()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateProfileKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestToPrefsRejectsInvalidProfileKey checks that ToPrefs propagates
+// ValidateProfileKeys' error instead of silently ignoring a malformed
+// Profile key.
+func TestToPrefsRejectsInvalidProfileKey(t *testing.T) {
+	c := &ConfigVAlpha{
+		Profile: map[string]*ConfigVAlpha{
+			"not-a-sid": {Hostname: ptr("should-not-apply")},
+		},
+	}
+	if _, err := c.ToPrefs(context.Background(), nil); err == nil {
+		t.Fatal("ToPrefs: want error for invalid Profile key, got nil")
+	}
+}
+
+// TestToPrefsProfileIgnoredWithoutLocalClient checks the documented
+// graceful fallback: with a nil LocalClient there is no way to
+// determine the current tailnet, so Profile entries are left
+// unapplied rather than causing an error.
+func TestToPrefsProfileIgnoredWithoutLocalClient(t *testing.T) {
+	c := &ConfigVAlpha{
+		Hostname: ptr("base-host"),
+		Profile: map[string]*ConfigVAlpha{
+			"alice@gmail.com": {Hostname: ptr("profile-host")},
+		},
+	}
+	mp, err := c.ToPrefs(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ToPrefs: %v", err)
+	}
+	if mp.Hostname != "base-host" {
+		t.Errorf("Hostname = %q, want %q (Profile should not apply without a LocalClient)", mp.Hostname, "base-host")
+	}
+}
+
+// TestMergeMaskedPrefs checks that mergeMaskedPrefs only overwrites
+// fields the override actually sets, and that an IP-based exit node
+// override clears a previously set ID-based one, so the two don't both
+// end up set.
+func TestMergeMaskedPrefs(t *testing.T) {
+	base := MaskedPrefs{HostnameSet: true, NoSNATSet: true}
+	base.Hostname = "base-host"
+	base.NoSNAT = false
+
+	override := MaskedPrefs{HostnameSet: true}
+	override.Hostname = "override-host"
+
+	got := mergeMaskedPrefs(base, override)
+	if got.Hostname != "override-host" {
+		t.Errorf("Hostname = %q, want %q", got.Hostname, "override-host")
+	}
+	if !got.NoSNATSet || got.NoSNAT != false {
+		t.Errorf("NoSNAT field from base was not preserved: %+v", got)
+	}
+
+	base2 := MaskedPrefs{ExitNodeIDSet: true}
+	base2.ExitNodeID = "nodeid:1234"
+	override2 := MaskedPrefs{ExitNodeIPSet: true}
+	override2.ExitNodeIP = netip.MustParseAddr("100.64.0.1")
+
+	got2 := mergeMaskedPrefs(base2, override2)
+	if !got2.ExitNodeIPSet || got2.ExitNodeIP != override2.ExitNodeIP {
+		t.Errorf("ExitNodeIP not applied: %+v", got2)
+	}
+	if got2.ExitNodeIDSet {
+		t.Errorf("ExitNodeIDSet should be cleared once ExitNodeIP is set: %+v", got2)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }